@@ -0,0 +1,140 @@
+// Online (single-pass) statistics over a stream of residue-pairwise
+// decomposition matrices, so decompProcessor can report the mean and
+// variance of every residue-pair interaction, plus the hotspot pairs
+// whose energy correlates most with total interaction energy, without
+// ever holding more than one matrix's worth of history (O(R^2) memory,
+// not O(R^2 * frames)).
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// ResidueCorrelationAccumulator maintains Welford running mean/variance
+// for each residue-pair interaction energy, plus its running covariance
+// with the frame's total interaction energy, updated one frame at a time.
+type ResidueCorrelationAccumulator struct {
+	NumResidues int
+	n           int64
+
+	mean []float64 // per-pair running mean
+	m2   []float64 // per-pair running sum of squared deviations
+
+	totalMean float64
+	totalM2   float64
+
+	coTotal []float64 // per-pair running co-moment against total energy
+}
+
+// NewResidueCorrelationAccumulator allocates an accumulator sized for a
+// numResidues x numResidues decomposition matrix.
+func NewResidueCorrelationAccumulator(numResidues int) *ResidueCorrelationAccumulator {
+	size := numResidues * numResidues
+	return &ResidueCorrelationAccumulator{
+		NumResidues: numResidues,
+		mean:        make([]float64, size),
+		m2:          make([]float64, size),
+		coTotal:     make([]float64, size),
+	}
+}
+
+// Update folds one frame's decomposition matrix into the running
+// statistics using Welford's online algorithm.
+func (acc *ResidueCorrelationAccumulator) Update(decomp []float64) {
+	var total float64
+	for _, e := range decomp {
+		total += e
+	}
+
+	acc.n++
+	n := float64(acc.n)
+	totalDelta := total - acc.totalMean
+	acc.totalMean += totalDelta / n
+	totalDelta2 := total - acc.totalMean
+	acc.totalM2 += totalDelta * totalDelta2
+
+	for i, e := range decomp {
+		delta := e - acc.mean[i]
+		acc.mean[i] += delta / n
+		delta2 := e - acc.mean[i]
+		acc.m2[i] += delta * delta2
+		acc.coTotal[i] += delta * totalDelta2
+	}
+}
+
+// Mean returns the per-pair mean interaction energy matrix.
+func (acc *ResidueCorrelationAccumulator) Mean() []float64 {
+	return acc.mean
+}
+
+// StdDev returns the per-pair sample standard deviation matrix.
+func (acc *ResidueCorrelationAccumulator) StdDev() []float64 {
+	stddev := make([]float64, len(acc.m2))
+	if acc.n < 2 {
+		return stddev
+	}
+	for i, m2 := range acc.m2 {
+		stddev[i] = math.Sqrt(m2 / float64(acc.n-1))
+	}
+	return stddev
+}
+
+// ResiduePairCorrelation is one residue-residue interaction's Pearson
+// correlation with the total interaction energy across the trajectory.
+type ResiduePairCorrelation struct {
+	ResI, ResJ  int
+	Correlation float64
+}
+
+// TopKByTotalCorrelation ranks the upper-triangular (i < j) residue pairs
+// by the magnitude of their Pearson correlation with total interaction
+// energy, returning at most k of them (all of them when k <= 0). Pairs
+// whose energy or the total has zero variance are left out. This is the "hotspot" residue
+// pairs people actually want out of a decomposition trajectory: the ones
+// whose fluctuations track the system's overall interaction energy.
+func (acc *ResidueCorrelationAccumulator) TopKByTotalCorrelation(k int) []ResiduePairCorrelation {
+	if acc.n < 2 {
+		return nil
+	}
+	var correlations []ResiduePairCorrelation
+	r := acc.NumResidues
+	for i := 0; i < r; i++ {
+		for j := i + 1; j < r; j++ {
+			idx := i*r + j
+			denom := math.Sqrt(acc.m2[idx] * acc.totalM2)
+			if denom == 0 {
+				continue
+			}
+			correlations = append(correlations, ResiduePairCorrelation{
+				ResI: i, ResJ: j,
+				Correlation: acc.coTotal[idx] / denom,
+			})
+		}
+	}
+	// Stable so that pairs with equal |correlation| keep the fixed,
+	// reproducible (res_i, res_j) order they were appended in above.
+	sort.SliceStable(correlations, func(a, b int) bool {
+		return math.Abs(correlations[a].Correlation) > math.Abs(correlations[b].Correlation)
+	})
+	if k > 0 && len(correlations) > k {
+		correlations = correlations[:k]
+	}
+	return correlations
+}
+
+// WriteTopKHotspots writes the ranked hotspot residue-pair list to
+// filename as plain text: "<resI> <resJ> <correlation>" per line.
+func WriteTopKHotspots(filename string, hotspots []ResiduePairCorrelation) error {
+	outFile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+	for _, h := range hotspots {
+		fmt.Fprintf(outFile, "%d %d %f\n", h.ResI, h.ResJ, h.Correlation)
+	}
+	return nil
+}
@@ -1,19 +1,38 @@
 // Calculates the nonbonded energy (vdW and electrostatic) in an AMBER system.
-// Assumes no cutoff. Does not calculate any other terms.
+// Assumes no cutoff, except that LennardJones is bounded by the cell-list
+// neighbor list's cutoff+skin whenever a periodic box is present, and the
+// -ewald real-space electrostatics is truncated at the cutoff. Does not
+// calculate any other terms.
 package main
 
 import (
 	"amber"
-	"bufio"
-	"compress/gzip"
 	"encoding/binary"
 	"flag"
 	"fmt"
 	"math"
 	"os"
-	"strings"
+	"runtime"
+	"sort"
+	"sync"
 )
 
+// trjFrameJob is one unit of work for the trajectory worker pool: the
+// coordinates (and box, if any) for a single frame.
+type trjFrameJob struct {
+	coords []float32
+	box    [3]float32
+	frame  int
+}
+
+// decompResult is a decomposition matrix tagged with the frame it came
+// from, so decompProcessor can restore frame order even though worker
+// goroutines finish in whatever order the scheduler hands them out.
+type decompResult struct {
+	frame  int
+	decomp []float64
+}
+
 func WriteInt32(file *os.File, d int) {
 	tmp := make([]uint8, 4)
 	binary.LittleEndian.PutUint32(tmp[0:4], uint32(d))
@@ -47,12 +66,24 @@ func main() {
 	var prmtopFilename, rstFilename, outFilename string
 	var stride int
 	var savePreprocessed bool
+	var useEwald bool
+	var ewaldAlpha, nonbondedCutoff, ewaldGridSpacing float64
+	var potentialsManifest string
+	var topK int
+	var reproducible bool
 
 	flag.StringVar(&prmtopFilename, "p", "prmtop", "Prmtop filename (required)")
 	flag.StringVar(&rstFilename, "c", "", "Inpcrd/rst filename")
 	flag.IntVar(&stride, "s", 1, "Frame stride; 1 = don't skip any")
 	flag.StringVar(&outFilename, "o", "energies.bin", "Energy decomposition output filename")
 	flag.BoolVar(&savePreprocessed, "e", false, "Save prmtop preprocessed output (use with -c)")
+	flag.BoolVar(&useEwald, "ewald", false, "Use Ewald summation for electrostatics instead of a no-cutoff sum (requires a periodic box)")
+	flag.Float64Var(&ewaldAlpha, "alpha", 0.3, "Ewald splitting parameter, 1/Angstrom")
+	flag.Float64Var(&nonbondedCutoff, "cutoff", 9.0, "Nonbonded cutoff, Angstrom: the Ewald real-space cutoff when -ewald is set, and in all cases the cell-list/neighbor-list cutoff used to skip distant pairs in LennardJones whenever a periodic box is present")
+	flag.Float64Var(&ewaldGridSpacing, "grid-spacing", 1.0, "Target reciprocal-space grid spacing for Ewald summation, Angstrom")
+	flag.StringVar(&potentialsManifest, "potentials", "", "Manifest file of per-atom-type-pair tabulated potentials to use instead of the prmtop's 6-12 coefficients")
+	flag.IntVar(&topK, "topk", 0, "Write the top K residue pairs most correlated with total interaction energy to hotspot_pairs.txt; 0 disables this")
+	flag.BoolVar(&reproducible, "reproducible", false, "Buffer and flush frames in strict order so two runs on the same trajectory produce byte-identical energies.bin and statistics")
 	flag.Parse()
 	trjFilenames := flag.Args()
 
@@ -71,6 +102,24 @@ func main() {
 		fmt.Println("No")
 	}
 
+	var ewald *EwaldParams
+	var prmtopBox [3]float32
+	if hasBox {
+		boxDims := amber.VectorAsFloat32Array(mol.Blocks["BOX_DIMENSIONS"])
+		// BOX_DIMENSIONS is (beta, x, y, z); we only support orthorhombic boxes.
+		if len(boxDims) >= 4 {
+			prmtopBox = [3]float32{boxDims[1], boxDims[2], boxDims[3]}
+		}
+	}
+	if useEwald {
+		if !hasBox {
+			fmt.Println("Ewald summation requires a periodic box, but this prmtop has none. Ignoring -ewald.")
+		} else {
+			ewald = &EwaldParams{Alpha: ewaldAlpha, Cutoff: nonbondedCutoff, GridSpacing: ewaldGridSpacing}
+			fmt.Printf("Using Ewald summation: alpha=%.4f cutoff=%.2f grid-spacing=%.2f\n", ewald.Alpha, ewald.Cutoff, ewald.GridSpacing)
+		}
+	}
+
 	// Set up nonbonded parameters. We load them here so we don't have to keep
 	// doing it later
 	var params NonbondedParamsCache
@@ -80,6 +129,15 @@ func main() {
 	params.LJ12 = amber.VectorAsFloat32Array(mol.Blocks["LENNARD_JONES_ACOEF"])    // CN1
 	params.LJ6 = amber.VectorAsFloat32Array(mol.Blocks["LENNARD_JONES_BCOEF"])     // CN2
 	params.Charges = amber.VectorAsFloat32Array(mol.Blocks["CHARGE"])
+	if potentialsManifest != "" {
+		overrides, err := LoadPairPotentialManifest(potentialsManifest)
+		if err != nil {
+			fmt.Println("Error loading potentials manifest:", err)
+			return
+		}
+		params.PairPotentials = overrides
+		fmt.Println("Loaded", len(overrides), "tabulated pair-potential override(s) from", potentialsManifest)
+	}
 	// If we were given a single snapshot, just do that one
 	if rstFilename != "" || savePreprocessed {
 		var request EnergyCalcRequest
@@ -96,6 +154,15 @@ func main() {
 		request.BondType = makeBondTypeTable(mol)
 		request.ResidueMap = makeResidueMap(mol)
 		request.Decomp = make([]float64, mol.NumResidues()*mol.NumResidues())
+		request.Ewald = ewald
+		request.Box = prmtopBox
+		if request.Box[0] > 0 && request.Box[1] > 0 && request.Box[2] > 0 {
+			// Build the cell-list neighbor list whenever there's a
+			// periodic box, not just when -ewald is set, so LennardJones
+			// gets the same speedup on the default (no-Ewald) path.
+			const neighborSkin = 2.0 // Angstrom
+			request.Neighbors = BuildNeighborList(request.Coords, request.Box, float32(nonbondedCutoff), neighborSkin)
+		}
 
 		// Dump the preprocessed info to a file so a C version of this program can easily load and parse it
 		if savePreprocessed {
@@ -137,14 +204,43 @@ func main() {
 		residueMap := makeResidueMap(mol)
 
 		ch := make(chan int)
-		decompCh := make(chan []float64, 32)
+		decompCh := make(chan decompResult, 32)
 		// This goroutine will be fed the decomposition matrices made by the energy functions
 		fmt.Println("Writing residue decomposition matrices to", outFilename)
-		go decompProcessor(outFilename, mol.NumResidues(), decompCh, ch)
+		go decompProcessor(outFilename, mol.NumResidues(), topK, reproducible, stride, decompCh, ch)
 		numAtoms := mol.NumAtoms()
 
+		// Fixed worker pool instead of one goroutine per frame, so we don't
+		// oversubscribe the machine on long trajectories.
+		numWorkers := runtime.NumCPU()
+		fmt.Println("Worker pool size:", numWorkers)
+		if gpuAvailable() {
+			fmt.Println("GPU backend: available")
+		}
+		jobCh := make(chan trjFrameJob, numWorkers*2)
+		var workerWg sync.WaitGroup
+		workerWg.Add(numWorkers)
+		for w := 0; w < numWorkers; w++ {
+			go func() {
+				defer workerWg.Done()
+				// Each worker keeps its own neighbor list across the frames
+				// it handles, rebuilding only when NeedsRebuild says atoms
+				// have drifted too far since it was built. With -reproducible
+				// the list is rebuilt for every frame, since which earlier
+				// frame a worker handled depends on scheduling and would
+				// change the pairs (and their order) the kernels sum.
+				var neighbors *NeighborList
+				for job := range jobCh {
+					if reproducible {
+						neighbors = nil
+					}
+					neighbors = calcSingleTrjFrame(mol, params, job.coords, job.box, job.frame, bondType, residueMap, ewald, nonbondedCutoff, neighbors, decompCh, ch)
+				}
+			}()
+		}
+
 		fileId := 0
-		trj, err := openTrj(trjFilenames[fileId])
+		trj, err := OpenTrajectory(trjFilenames[fileId], numAtoms, hasBox)
 		if err != nil {
 			return
 		}
@@ -155,114 +251,183 @@ func main() {
 
 		for {
 			// If there was an error reading the next frame, move on to the next trajectory file
-			coords, err := amber.GetNextFrameFromTrajectory(trj, numAtoms, hasBox)
+			coords, box, err := trj.NextFrame()
 			if err != nil {
+				trj.Close()
 				fileId++
 				if fileId >= len(trjFilenames) {
 					break
 				}
-				trj, err = openTrj(trjFilenames[fileId])
+				trj, err = OpenTrajectory(trjFilenames[fileId], numAtoms, hasBox)
 				if err != nil {
 					fmt.Println("Error opening", trjFilenames[fileId])
 					break
 				}
-				coords, err = amber.GetNextFrameFromTrajectory(trj, numAtoms, hasBox)
+				coords, box, err = trj.NextFrame()
 				if err != nil {
 					fmt.Printf("Trajectory file %s doesn't have even one valid frame\n", trjFilenames[fileId])
 					break
 				}
 			}
+			// If the prmtop has no box, fall back to the one read from the prmtop
+			// (usually zero), since no per-frame box is present in the trajectory.
+			if !hasBox {
+				box = prmtopBox
+			}
 			frame++
 			// Only actually process the frames indicated by stride
 			strideCountdown--
 			if strideCountdown == 0 {
 				strideCountdown = stride
-				go calcSingleTrjFrame(mol, params, coords, frame, bondType, residueMap, decompCh, ch)
+				jobCh <- trjFrameJob{coords: coords, box: box, frame: frame}
 				numKids++
 			}
 		}
+		close(jobCh)
+		workerWg.Wait()
 
 		if false {
 			for i := 0; i < numKids; i++ {
 				<-ch
 			}
 		}
-		decompCh <- nil
+		decompCh <- decompResult{frame: decompProcessorDoneFrame}
 		<-ch // Wait for decompProcessor to finish
 	}
 }
 
+// decompProcessorDoneFrame is the sentinel frame ID used to tell
+// decompProcessor there are no more frames coming.
+const decompProcessorDoneFrame = -1
+
 // Does something with each decomposition matrix, which is currently writing them to disk.
 // This is a separate goroutine so that only one matrix is processed at a time, which is
 // convenient for writing to a disk.
-// XXX: Matrices are written out of order because we receive them in arbitrary order.
-// That should be OK for the correlation analysis though.
-func decompProcessor(filename string, numResidues int, ch chan []float64, termCh chan int) {
+// In reproducible mode, results are buffered by frame ID and flushed in strict
+// frame order (a bounded reorder window warns if too many frames pile up waiting
+// on a straggler); otherwise matrices are written in whatever order workers finish,
+// which is faster but means two runs of the same trajectory need not produce
+// byte-identical output.
+func decompProcessor(filename string, numResidues int, topK int, reproducible bool, stride int, ch chan decompResult, termCh chan int) {
+	const reorderWindowWarning = 256 // advisory; doesn't bound correctness, just flags stragglers
+
 	// Output file
 	outFile, _ := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	defer outFile.Close()
 	tmp := make([]byte, numResidues*numResidues*4) // for converting to bytes
-	for {
-		decomp := <-ch
-		if decomp == nil {
-			break
-		}
-		// Actually do stuff with the data here.
-		// We could in theory do the correlation stuff now, but maybe we should
-		// just write the frames to disk.
+	stats := NewResidueCorrelationAccumulator(numResidues)
+
+	process := func(decomp []float64) {
 		// Dump to file. We have to explicitly convert to bytes. Yay.
 		for j, n := range decomp {
 			binary.LittleEndian.PutUint32(tmp[j*4:j*4+4], math.Float32bits(float32(n)))
 		}
 		outFile.Write(tmp)
 
+		// Fold this frame into the running mean/variance/correlation
+		// statistics before the matrix is reused or dropped.
+		stats.Update(decomp)
+
 		// Put this decomp buffer back on the free list, or drop it on the floor for the GC
 		// to collect if there's no room (the assignment makes it nonblocking and don't care if fail)
 		decompFreeList <- decomp
 	}
-	fmt.Println("decompProcessor finished. I wrote to", filename)
-	termCh <- 0 // Tell caller we're done
-}
 
-func openTrj(filename string) (*bufio.Reader, error) {
-	// Or, do the trajectory.
-	trjFp, err := os.Open(filename)
-	if err != nil {
-		fmt.Println("Error opening", filename, err)
-		return nil, err
-	}
-	//defer trjFp.Close()
-	// A File is a Reader
-	//trjOrig := bufio.NewReader(trjFp)
-	var trj *bufio.Reader
-	if strings.HasSuffix(filename, ".gz") {
-		inflater, err := gzip.NewReader(trjFp)
-		if err != nil {
-			fmt.Println("Not actually a gzip file: ", filename, err)
-			return nil, err
+	if !reproducible {
+		for {
+			result := <-ch
+			if result.frame == decompProcessorDoneFrame {
+				break
+			}
+			process(result.decomp)
 		}
-		trj = bufio.NewReader(inflater)
 	} else {
-		trj = bufio.NewReader(trjFp)
+		pending := make(map[int][]float64)
+		// Submitted frame IDs are the real trajectory frame counter, which
+		// advances by stride (not 1) whenever -s > 1, so nextFrame has to
+		// track the same stride or it never matches a pending key and
+		// nothing flushes until the run ends.
+		nextFrame := stride
+		warned := false
+		for {
+			result := <-ch
+			if result.frame == decompProcessorDoneFrame {
+				break
+			}
+			pending[result.frame] = result.decomp
+			for {
+				decomp, ok := pending[nextFrame]
+				if !ok {
+					break
+				}
+				process(decomp)
+				delete(pending, nextFrame)
+				nextFrame += stride
+			}
+			if !warned && len(pending) > reorderWindowWarning {
+				fmt.Printf("decompProcessor: %d frames buffered waiting on frame %d; a worker may be stuck\n", len(pending), nextFrame)
+				warned = true
+			}
+		}
+		// Flush whatever's left, in frame order, once the stream is done.
+		remaining := make([]int, 0, len(pending))
+		for frame := range pending {
+			remaining = append(remaining, frame)
+		}
+		sort.Ints(remaining)
+		for _, frame := range remaining {
+			process(pending[frame])
+		}
 	}
-	trj.ReadString('\n') // Eat header line
-	fmt.Println("Opened", filename)
-	return trj, nil
+	fmt.Println("decompProcessor finished. I wrote to", filename)
+
+	amber.DumpFloat64MatrixAsText(stats.Mean(), numResidues, "mean_decomp.txt")
+	amber.DumpFloat64MatrixAsText(stats.StdDev(), numResidues, "stddev_decomp.txt")
+	fmt.Println("Saved mean and standard-deviation decomposition matrices to mean_decomp.txt and stddev_decomp.txt")
+	if topK > 0 {
+		hotspots := stats.TopKByTotalCorrelation(topK)
+		if err := WriteTopKHotspots("hotspot_pairs.txt", hotspots); err != nil {
+			fmt.Println("Error writing hotspot_pairs.txt:", err)
+		} else {
+			fmt.Println("Saved top", len(hotspots), "hotspot residue pairs to hotspot_pairs.txt")
+		}
+	}
+
+	termCh <- 0 // Tell caller we're done
 }
 
+// Trajectory opening/format-detection now lives in trajectory.go, behind
+// the TrajectoryReader interface (see OpenTrajectory).
+
 var decompFreeList = make(chan []float64, 32)
 
 // Calculates the nonbonded energies for a single snapshot.
-// Results are returned through reqOutCh.
-func calcSingleTrjFrame(mol *amber.System, params NonbondedParamsCache, coords []float32, frame int, bondType []uint8, residueMap []int, reqOutCh chan []float64, ch chan int) {
+// Results are returned through reqOutCh, tagged with the frame ID so the
+// receiver can restore frame order regardless of which worker finishes
+// first. neighbors is the caller's neighbor list from the previous frame
+// it handled (nil if none yet); calcSingleTrjFrame reuses it unless
+// NeedsRebuild says atoms have drifted too far, and returns the list the
+// caller should pass in next time.
+func calcSingleTrjFrame(mol *amber.System, params NonbondedParamsCache, coords []float32, box [3]float32, frame int, bondType []uint8, residueMap []int, ewald *EwaldParams, nonbondedCutoff float64, neighbors *NeighborList, reqOutCh chan decompResult, ch chan int) *NeighborList {
 
 	var request EnergyCalcRequest
 	request.Molecule = mol
 	request.Frame = frame
 	request.NBParams = params
 	request.Coords = coords
+	request.Box = box
 	request.BondType = bondType
 	request.ResidueMap = residueMap
+	request.Ewald = ewald
+	if box[0] > 0 && box[1] > 0 && box[2] > 0 {
+		const neighborSkin = 2.0 // Angstrom
+		if neighbors == nil || neighbors.NeedsRebuild(coords) {
+			neighbors = BuildNeighborList(coords, box, float32(nonbondedCutoff), neighborSkin)
+		}
+	} else {
+		neighbors = nil
+	}
+	request.Neighbors = neighbors
 	var ok bool
 	request.Decomp = <-decompFreeList
 	if !ok {
@@ -295,9 +460,10 @@ func calcSingleTrjFrame(mol *amber.System, params NonbondedParamsCache, coords [
 
 	// Send request to listening something that will probably average the decomp matrix
 	// but could in theory do whatever it wants.
-	reqOutCh <- request.Decomp
+	reqOutCh <- decompResult{frame: frame, decomp: request.Decomp}
 	// Return frame ID through channel
 	//ch <- frame
+	return neighbors
 }
 
 // This is probably a little unwieldy but I hope it's better than
@@ -306,8 +472,9 @@ type EnergyCalcRequest struct {
 	Molecule   *amber.System
 	Frame      int // Frame ID
 	Coords     []float32
-	BondType   []uint8 // Input: Bond type matrix
-	ResidueMap []int   // Input: Maps atom id to residue id
+	Box        [3]float32 // Orthorhombic box side lengths for this frame; zero if no box
+	BondType   []uint8    // Input: Bond type matrix
+	ResidueMap []int      // Input: Maps atom id to residue id
 	// Output: pairwise residue-residue interaction energies
 	Decomp []float64 // Lots of math going on here so float64
 	Energy float64
@@ -315,6 +482,14 @@ type EnergyCalcRequest struct {
 	// Parameters for nonbonded energy calculations.
 	// Charges, LJ coefficients, and so on
 	NBParams NonbondedParamsCache
+
+	// If non-nil, Electro uses Ewald summation with periodic boundary
+	// conditions instead of the plain no-cutoff Coulomb sum.
+	Ewald *EwaldParams
+
+	// If non-nil, LennardJones and ElectroEwald iterate neighbor pairs
+	// from this Verlet list instead of every atom pair.
+	Neighbors *NeighborList
 }
 
 // Place to stash preloaded parameters for nonbonded energy calculations
@@ -322,6 +497,11 @@ type NonbondedParamsCache struct {
 	Ntypes                     int
 	NBIndices, AtomTypeIndices []int
 	LJ12, LJ6, Charges         []float32
+
+	// Per-atom-type-pair overrides of the standard 6-12 potential, keyed
+	// by pairPotentialKey(typeI, typeJ). Pairs with no entry here still
+	// use LJ12/LJ6 as before.
+	PairPotentials map[[2]int]PairPotential
 }
 
 // Computes the Lennard-Jones 6-12 energy
@@ -356,43 +536,72 @@ func LennardJones(request *EnergyCalcRequest) float64 {
 	lj6 := request.NBParams.LJ6
 	numAtoms := mol.NumAtoms()
 	numResidues := mol.NumResidues()
+	neighbors := request.Neighbors
 	var energy float64
-	for atom_i := 0; atom_i < numAtoms; atom_i++ {
-		// Get coordinates for atom i
-		offs_i := atom_i * 3
-		x0, y0, z0 := coords[offs_i], coords[offs_i+1], coords[offs_i+2]
-		// Pulled some of the matrix indexing out of the inner loop
-		nbparm_offs_i := ntypes * (atomTypeIndices[atom_i] - 1)
-		bondtype_offs_i := atom_i * numAtoms
-		i_res := residueMap[atom_i] // Residue of atom i
-
-		for atom_j := 0; atom_j < atom_i; atom_j++ {
-			// Are these atoms connected by a bond or angle? If so, skip.
-			thisBondType := bondType[bondtype_offs_i+atom_j]
-			if thisBondType&(BOND|ANGLE) != 0 {
-				continue
+	// Tile atoms in blocks of TileSize so the coordinate/charge/type data
+	// for each block stays hot in cache across the inner loop below.
+	for tileStart := 0; tileStart < numAtoms; tileStart += TileSize {
+		tileEnd := tileStart + TileSize
+		if tileEnd > numAtoms {
+			tileEnd = numAtoms
+		}
+		for atom_i := tileStart; atom_i < tileEnd; atom_i++ {
+			// Get coordinates for atom i
+			offs_i := atom_i * 3
+			x0, y0, z0 := coords[offs_i], coords[offs_i+1], coords[offs_i+2]
+			// Pulled some of the matrix indexing out of the inner loop
+			nbparm_offs_i := ntypes * (atomTypeIndices[atom_i] - 1)
+			bondtype_offs_i := atom_i * numAtoms
+			i_res := residueMap[atom_i] // Residue of atom i
+
+			lj := func(atom_j int) {
+				// Are these atoms connected by a bond or angle? If so, skip.
+				thisBondType := bondType[bondtype_offs_i+atom_j]
+				if thisBondType&(BOND|ANGLE) != 0 {
+					return
+				}
+				// Calculate distance reciprocals
+				offs_j := atom_j * 3
+				x1, y1, z1 := coords[offs_j], coords[offs_j+1], coords[offs_j+2]
+				dx, dy, dz := x1-x0, y1-y0, z1-z0
+				r2 := dx*dx + dy*dy + dz*dz
+
+				var thisEnergy float64
+				if override := request.NBParams.PairPotentials[pairPotentialKey(atomTypeIndices[atom_i], atomTypeIndices[atom_j])]; override != nil {
+					// A user-supplied tabulated/shifted/switched potential
+					// replaces the prmtop's own 6-12 coefficients for this
+					// atom-type pair.
+					thisEnergy = override.Energy(float64(r2))
+				} else {
+					distRecip := Invsqrt32(r2)
+					distRecip3 := distRecip * distRecip * distRecip
+					distRecip6 := distRecip3 * distRecip3
+
+					// Locate L-J parameters for this atom pair
+					index := nbIndices[nbparm_offs_i+atomTypeIndices[atom_j]-1] - 1
+					// A/r12 - C/r6
+					thisEnergy = float64(lj12[index]*distRecip6*distRecip6 - lj6[index]*distRecip6)
+				}
+				// Are these atoms 1-4 to each other? If so, divide the energy
+				// by 2.0, as ff99 et al dictate.
+				if thisBondType&DIHEDRAL != 0 {
+					thisEnergy *= VDW_14_SCALING_RECIP
+				}
+				// Pairwise residue energy decomposition - symmetric
+				decomp[i_res*numResidues+residueMap[atom_j]] += thisEnergy
+				decomp[i_res+residueMap[atom_j]*numResidues] += thisEnergy
+				energy += thisEnergy
 			}
-			// Calculate distance reciprocals
-			offs_j := atom_j * 3
-			x1, y1, z1 := coords[offs_j], coords[offs_j+1], coords[offs_j+2]
-			dx, dy, dz := x1-x0, y1-y0, z1-z0
-			distRecip := Invsqrt32(dx*dx + dy*dy + dz*dz)
-			distRecip3 := distRecip * distRecip * distRecip
-			distRecip6 := distRecip3 * distRecip3
-
-			// Locate L-J parameters for this atom pair
-			index := nbIndices[nbparm_offs_i+atomTypeIndices[atom_j]-1] - 1
-			// A/r12 - C/r6
-			thisEnergy := float64(lj12[index]*distRecip6*distRecip6 - lj6[index]*distRecip6)
-			// Are these atoms 1-4 to each other? If so, divide the energy
-			// by 2.0, as ff99 et al dictate.
-			if thisBondType&DIHEDRAL != 0 {
-				thisEnergy *= VDW_14_SCALING_RECIP
+
+			if neighbors != nil {
+				for _, atom_j32 := range neighbors.Pairs[atom_i] {
+					lj(int(atom_j32))
+				}
+			} else {
+				for atom_j := 0; atom_j < atom_i; atom_j++ {
+					lj(atom_j)
+				}
 			}
-			// Pairwise residue energy decomposition - symmetric
-			decomp[i_res*numResidues+residueMap[atom_j]] += thisEnergy
-			decomp[i_res+residueMap[atom_j]*numResidues] += thisEnergy
-			energy += thisEnergy
 		}
 	}
 	request.Energy = energy
@@ -400,8 +609,13 @@ func LennardJones(request *EnergyCalcRequest) float64 {
 }
 
 // Calculates electrostatic interactions among all particles in an amber.System,
-// according to the force field (e.g. don't include bonded atoms)
+// according to the force field (e.g. don't include bonded atoms).
+// If request.Ewald is set, this dispatches to the periodic Ewald-summation
+// implementation in ewald.go instead of the no-cutoff sum below.
 func Electro(request *EnergyCalcRequest) float64 {
+	if request.Ewald != nil {
+		return ElectroEwald(request)
+	}
 	const COULOMB = 332.0636
 	const EEL_14_SCALING_RECIP = 1 / 1.2
 	mol := request.Molecule
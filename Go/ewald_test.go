@@ -0,0 +1,110 @@
+// Correctness checks for the Ewald-summation pieces in ewald.go. These
+// don't go through ElectroEwald itself, since that needs a live
+// *amber.System for NumResidues(); instead they exercise the pure
+// numeric building blocks it's assembled from.
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// TestErfcErfComplement checks the identity the exclusion-correction fix
+// in ElectroEwald depends on: the erfc-screened real-space term and the
+// erf-screened exclusion correction must sum back to the plain 1/r
+// Coulomb potential, for any alpha and r. If this doesn't hold, an
+// excluded pair's real+reciprocal contribution won't cancel down to
+// zero the way a bonded/1-3 pair's direct Coulomb interaction should.
+func TestErfcErfComplement(t *testing.T) {
+	cases := []struct {
+		alpha, r float64
+	}{
+		{0.3, 1.0},
+		{0.3, 5.0},
+		{0.35, 2.5},
+		{1.0, 0.5},
+		{0.1, 10.0},
+	}
+	for _, c := range cases {
+		got := math.Erfc(c.alpha*c.r)/c.r + math.Erf(c.alpha*c.r)/c.r
+		want := 1 / c.r
+		if math.Abs(got-want) > 1e-12 {
+			t.Errorf("alpha=%v r=%v: erfc/r + erf/r = %v, want %v", c.alpha, c.r, got, want)
+		}
+	}
+}
+
+// TestEwaldSelf checks ewaldSelf against the closed-form
+// -COULOMB*alpha/sqrt(pi)*sum(qi^2) directly, for a couple of charge
+// sets including the symmetric +q/-q pair the self term doesn't
+// distinguish from any other charge assignment.
+func TestEwaldSelf(t *testing.T) {
+	const coulomb = 332.0636
+	cases := []struct {
+		name    string
+		charges []float32
+		alpha   float64
+	}{
+		{"symmetric pair", []float32{1, -1}, 0.3},
+		{"three charges", []float32{0.5, -0.25, -0.25}, 0.25},
+	}
+	for _, c := range cases {
+		var sumQ2 float64
+		for _, q := range c.charges {
+			sumQ2 += float64(q) * float64(q)
+		}
+		want := -coulomb * c.alpha / math.Sqrt(math.Pi) * sumQ2
+		got := ewaldSelf(c.charges, c.alpha)
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s: ewaldSelf = %v, want %v", c.name, got, want)
+		}
+		if got >= 0 {
+			t.Errorf("%s: ewaldSelf = %v, want a negative correction", c.name, got)
+		}
+	}
+}
+
+// TestEwaldReciprocalLatticeInvariance checks that translating each atom
+// by an exact box vector (the first by +L in x, the second by -L in z)
+// doesn't change the reciprocal-space energy, since every k-vector
+// satisfies k.L = 2*pi*integer in each dimension. A broken k-vector or structure
+// factor computation is likely to break this invariance even if it
+// doesn't crash.
+func TestEwaldReciprocalLatticeInvariance(t *testing.T) {
+	box := [3]float32{20, 20, 20}
+	charges := []float32{1, -1}
+	alpha := 0.3
+	gridSpacing := 1.5
+
+	coordsA := []float32{1, 2, 3, 5, 6, 7}
+	coordsB := []float32{1 + 20, 2, 3, 5, 6, 7 - 20}
+
+	eA := ewaldReciprocal(coordsA, charges, box, alpha, gridSpacing)
+	eB := ewaldReciprocal(coordsB, charges, box, alpha, gridSpacing)
+	if math.Abs(eA-eB) > 1e-6*math.Max(1, math.Abs(eA)) {
+		t.Errorf("reciprocal energy not periodic: unshifted=%v shifted=%v", eA, eB)
+	}
+
+	if eA <= 0 {
+		t.Errorf("reciprocal energy for a symmetric +/-q pair should be positive, got %v", eA)
+	}
+}
+
+// TestMinimumImage checks that displacements are wrapped into (-L/2, L/2].
+func TestMinimumImage(t *testing.T) {
+	cases := []struct {
+		d, length, want float32
+	}{
+		{5, 10, 5},
+		{6, 10, -4},
+		{-6, 10, 4},
+		{0, 10, 0},
+		{3, 0, 3}, // non-periodic dimension: passed through unchanged
+	}
+	for _, c := range cases {
+		got := minimumImage(c.d, c.length)
+		if math.Abs(float64(got-c.want)) > 1e-6 {
+			t.Errorf("minimumImage(%v, %v) = %v, want %v", c.d, c.length, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !opencl
+// +build !opencl
+
+// Default (CPU-only) build: no GPU backend is compiled in. See
+// gpu_opencl.go for the optional CGO/OpenCL offload path, enabled with
+// `go build -tags opencl`.
+package main
+
+// gpuAvailable reports whether a GPU pair-potential backend was compiled
+// in. The CPU worker pool and neighbor-list kernels in coulomb.go are used
+// whenever this is false.
+func gpuAvailable() bool {
+	return false
+}
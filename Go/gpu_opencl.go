@@ -0,0 +1,24 @@
+//go:build opencl
+// +build opencl
+
+// Optional CGO/OpenCL offload path for the nonbonded pair kernels, enabled
+// with `go build -tags opencl`. It contains no device kernels: gpuAvailable
+// only reports whether an OpenCL platform is present, and the pair loops
+// in coulomb.go/neighborlist.go run on the CPU whatever it returns.
+package main
+
+// #cgo LDFLAGS: -lOpenCL
+// #include <CL/cl.h>
+import "C"
+
+// gpuAvailable reports whether a GPU pair-potential backend was compiled
+// in and has a usable OpenCL platform. It's honest about platform
+// detection, but the actual pair kernel hasn't been written yet, so even
+// when this returns true callers should keep using the CPU path for now.
+func gpuAvailable() bool {
+	var numPlatforms C.cl_uint
+	if C.clGetPlatformIDs(0, nil, &numPlatforms) != C.CL_SUCCESS {
+		return false
+	}
+	return numPlatforms > 0
+}
@@ -0,0 +1,82 @@
+// Benchmarks comparing the cell-list neighbor search in BuildNeighborList
+// against the plain O(N^2) double loop it replaces, to quantify the
+// speedup promised when this kernel redesign was proposed.
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// benchCoords lays out numAtoms random coordinates in a cubic box of side
+// boxSide. Atom density (and therefore neighbor-list occupancy) is
+// numAtoms/boxSide^3, so for a fixed boxSide it grows with numAtoms.
+func benchCoords(numAtoms int, boxSide float32) []float32 {
+	r := rand.New(rand.NewSource(42))
+	coords := make([]float32, numAtoms*3)
+	for i := range coords {
+		coords[i] = r.Float32() * boxSide
+	}
+	return coords
+}
+
+// bruteForcePairCount is the O(N^2) double loop BuildNeighborList replaces.
+func bruteForcePairCount(coords []float32, box [3]float32, cutoff float32) int {
+	numAtoms := len(coords) / 3
+	cutoff2 := cutoff * cutoff
+	count := 0
+	for i := 0; i < numAtoms; i++ {
+		offsI := i * 3
+		for j := 0; j < i; j++ {
+			offsJ := j * 3
+			dx := minimumImage(coords[offsI]-coords[offsJ], box[0])
+			dy := minimumImage(coords[offsI+1]-coords[offsJ+1], box[1])
+			dz := minimumImage(coords[offsI+2]-coords[offsJ+2], box[2])
+			if dx*dx+dy*dy+dz*dz <= cutoff2 {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func benchmarkParams(numAtoms int) ([]float32, [3]float32, float32, float32) {
+	const boxSide = 60.0 // Angstrom; roughly a small solvated peptide's box
+	box := [3]float32{boxSide, boxSide, boxSide}
+	coords := benchCoords(numAtoms, boxSide)
+	return coords, box, 9.0, 2.0 // default -cutoff and neighborSkin
+}
+
+func BenchmarkBruteForcePairSearch1000(b *testing.B) {
+	coords, box, cutoff, skin := benchmarkParams(1000)
+	cutoffWithSkin := cutoff + skin
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bruteForcePairCount(coords, box, cutoffWithSkin)
+	}
+}
+
+func BenchmarkCellListPairSearch1000(b *testing.B) {
+	coords, box, cutoff, skin := benchmarkParams(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BuildNeighborList(coords, box, cutoff, skin)
+	}
+}
+
+func BenchmarkBruteForcePairSearch10000(b *testing.B) {
+	coords, box, cutoff, skin := benchmarkParams(10000)
+	cutoffWithSkin := cutoff + skin
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bruteForcePairCount(coords, box, cutoffWithSkin)
+	}
+}
+
+func BenchmarkCellListPairSearch10000(b *testing.B) {
+	coords, box, cutoff, skin := benchmarkParams(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BuildNeighborList(coords, box, cutoff, skin)
+	}
+}
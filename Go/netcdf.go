@@ -0,0 +1,343 @@
+// Minimal reader for the classic (CDF-1/CDF-2) NetCDF binary format AMBER
+// writes its binary trajectories in. This only implements enough of the
+// format to locate the "coordinates" and, if present, "cell_lengths"
+// record variables and stream frames from them — it is not a general
+// NetCDF library.
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	ncDimensionTag = 10
+	ncVariableTag  = 11
+	ncAttributeTag = 12
+
+	ncByte   = 1
+	ncChar   = 2
+	ncShort  = 3
+	ncInt    = 4
+	ncFloat  = 5
+	ncDouble = 6
+)
+
+var ncTypeSize = map[int32]int64{ncByte: 1, ncChar: 1, ncShort: 2, ncInt: 4, ncFloat: 4, ncDouble: 8}
+
+type ncDim struct {
+	name   string
+	length int32 // 0 means this is the unlimited (record) dimension
+}
+
+type ncVar struct {
+	name   string
+	dimids []int32
+	nctype int32
+	vsize  int32
+	begin  int64
+}
+
+type netCDFTrajectoryReader struct {
+	fp             *os.File
+	numAtoms       int
+	frame          int
+	numFrames      int // 0 if unknown (streaming)
+	recSize        int64
+	coordsVar      *ncVar
+	cellLengthsVar *ncVar // nil if the file has no box
+}
+
+func newNetCDFTrajectoryReader(filename string) (TrajectoryReader, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := &netCDFTrajectoryReader{fp: fp}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(fp, magic[:]); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if string(magic[:3]) != "CDF" {
+		fp.Close()
+		return nil, fmt.Errorf("%s: not a NetCDF classic file", filename)
+	}
+	version := magic[3]
+	if version != 1 && version != 2 {
+		fp.Close()
+		return nil, fmt.Errorf("%s: unsupported NetCDF version %d (only classic CDF-1/CDF-2 supported)", filename, version)
+	}
+
+	numrecs, err := readInt32(fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	_ = numrecs // -1 (streaming) or the actual record count; we rely on EOF either way
+
+	dims, err := readNCDimList(fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	if err := skipNCAttList(fp); err != nil {
+		fp.Close()
+		return nil, err
+	}
+	vars, err := readNCVarList(fp, version)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+
+	unlimitedDim := int32(-1)
+	for i, d := range dims {
+		if d.length == 0 {
+			unlimitedDim = int32(i)
+			break
+		}
+	}
+
+	var recSize int64
+	for i := range vars {
+		v := &vars[i]
+		if len(v.dimids) > 0 && v.dimids[0] == unlimitedDim {
+			recSize += int64(v.vsize)
+		}
+		switch v.name {
+		case "coordinates":
+			r.coordsVar = v
+		case "cell_lengths":
+			r.cellLengthsVar = v
+		}
+	}
+	if r.coordsVar == nil {
+		fp.Close()
+		return nil, fmt.Errorf("%s: no \"coordinates\" variable found", filename)
+	}
+	// atom dimension is coordinates' second dimension (frame, atom, spatial)
+	if len(r.coordsVar.dimids) >= 2 {
+		r.numAtoms = int(dims[r.coordsVar.dimids[1]].length)
+	}
+	r.recSize = recSize
+	fmt.Println("Opened", filename, "(NetCDF,", r.numAtoms, "atoms)")
+	return r, nil
+}
+
+func (r *netCDFTrajectoryReader) NextFrame() ([]float32, [3]float32, error) {
+	coords := make([]float32, r.numAtoms*3)
+	offset := r.coordsVar.begin + int64(r.frame)*r.recSize
+	if _, err := r.fp.Seek(offset, io.SeekStart); err != nil {
+		return nil, [3]float32{}, err
+	}
+	for i := range coords {
+		v, err := readFloat32(r.fp)
+		if err != nil {
+			return nil, [3]float32{}, err
+		}
+		coords[i] = v
+	}
+
+	var box [3]float32
+	if r.cellLengthsVar != nil {
+		boxOffset := r.cellLengthsVar.begin + int64(r.frame)*r.recSize
+		if _, err := r.fp.Seek(boxOffset, io.SeekStart); err == nil {
+			for i := 0; i < 3; i++ {
+				v, err := readFloat64(r.fp)
+				if err != nil {
+					break
+				}
+				box[i] = float32(v)
+			}
+		}
+	}
+
+	r.frame++
+	return coords, box, nil
+}
+
+func (r *netCDFTrajectoryReader) Close() error {
+	return r.fp.Close()
+}
+
+func readInt32(r io.Reader) (int32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return int32(binary.BigEndian.Uint32(buf[:])), nil
+}
+
+func readInt64(r io.Reader) (int64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.BigEndian.Uint64(buf[:])), nil
+}
+
+func readFloat32(r io.Reader) (float32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float32frombits(binary.BigEndian.Uint32(buf[:])), nil
+}
+
+func readFloat64(r io.Reader) (float64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(buf[:])), nil
+}
+
+// readNCName reads a NetCDF "name": a 4-byte length followed by that many
+// bytes, padded to a 4-byte boundary.
+func readNCName(r io.Reader) (string, error) {
+	n, err := readInt32(r)
+	if err != nil {
+		return "", err
+	}
+	padded := (int(n) + 3) &^ 3
+	buf := make([]byte, padded)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+func readNCDimList(r io.Reader) ([]ncDim, error) {
+	tag, err := readInt32(r)
+	if err != nil {
+		return nil, err
+	}
+	nelems, err := readInt32(r)
+	if err != nil {
+		return nil, err
+	}
+	if nelems == 0 {
+		return nil, nil
+	}
+	if tag != ncDimensionTag {
+		return nil, fmt.Errorf("netcdf: expected dim_list tag, got %d", tag)
+	}
+	dims := make([]ncDim, nelems)
+	for i := range dims {
+		name, err := readNCName(r)
+		if err != nil {
+			return nil, err
+		}
+		length, err := readInt32(r)
+		if err != nil {
+			return nil, err
+		}
+		dims[i] = ncDim{name: name, length: length}
+	}
+	return dims, nil
+}
+
+// skipNCAttList reads (and discards) a global-attribute list; we don't
+// need any of AMBER's conventions/title attributes to find the frames.
+func skipNCAttList(r io.Reader) error {
+	tag, err := readInt32(r)
+	if err != nil {
+		return err
+	}
+	nelems, err := readInt32(r)
+	if err != nil {
+		return err
+	}
+	if nelems == 0 {
+		return nil
+	}
+	if tag != ncAttributeTag {
+		return fmt.Errorf("netcdf: expected att_list tag, got %d", tag)
+	}
+	for i := int32(0); i < nelems; i++ {
+		if _, err := readNCName(r); err != nil {
+			return err
+		}
+		nctype, err := readInt32(r)
+		if err != nil {
+			return err
+		}
+		valNelems, err := readInt32(r)
+		if err != nil {
+			return err
+		}
+		size, ok := ncTypeSize[nctype]
+		if !ok {
+			return fmt.Errorf("netcdf: unknown attribute type %d", nctype)
+		}
+		total := (int64(valNelems)*size + 3) &^ 3
+		if _, err := io.CopyN(io.Discard, r, total); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readNCVarList(r io.Reader, version byte) ([]ncVar, error) {
+	tag, err := readInt32(r)
+	if err != nil {
+		return nil, err
+	}
+	nelems, err := readInt32(r)
+	if err != nil {
+		return nil, err
+	}
+	if nelems == 0 {
+		return nil, nil
+	}
+	if tag != ncVariableTag {
+		return nil, fmt.Errorf("netcdf: expected var_list tag, got %d", tag)
+	}
+	vars := make([]ncVar, nelems)
+	for i := range vars {
+		name, err := readNCName(r)
+		if err != nil {
+			return nil, err
+		}
+		ndims, err := readInt32(r)
+		if err != nil {
+			return nil, err
+		}
+		dimids := make([]int32, ndims)
+		for d := range dimids {
+			dimids[d], err = readInt32(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := skipNCAttList(r); err != nil {
+			return nil, err
+		}
+		nctype, err := readInt32(r)
+		if err != nil {
+			return nil, err
+		}
+		vsize, err := readInt32(r)
+		if err != nil {
+			return nil, err
+		}
+		var begin int64
+		if version == 2 {
+			begin, err = readInt64(r)
+		} else {
+			var begin32 int32
+			begin32, err = readInt32(r)
+			begin = int64(begin32)
+		}
+		if err != nil {
+			return nil, err
+		}
+		vars[i] = ncVar{name: name, dimids: dimids, nctype: nctype, vsize: vsize, begin: begin}
+	}
+	return vars, nil
+}
@@ -0,0 +1,114 @@
+// Correctness check for the classic-NetCDF header parser in netcdf.go,
+// against a minimal synthetic CDF-1 file built by hand in this test (one
+// "frame" record dimension, one "atom" dimension, a single "coordinates"
+// variable, no global or variable attributes) rather than a real AMBER
+// trajectory, since none ships with this repo.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+// writeNCName writes a NetCDF "name": a 4-byte length followed by that
+// many bytes, padded to a 4-byte boundary, matching readNCName.
+func writeNCName(buf *bytes.Buffer, name string) {
+	binary.Write(buf, binary.BigEndian, int32(len(name)))
+	buf.WriteString(name)
+	if pad := (4 - len(name)%4) % 4; pad > 0 {
+		buf.Write(make([]byte, pad))
+	}
+}
+
+// buildSyntheticNetCDF assembles a minimal classic (version 1) NetCDF
+// file with a "frame" unlimited dimension, an "atom" dimension of size
+// numAtoms, and a single "coordinates" variable over (frame, atom), then
+// appends one frame of the given coordinate data.
+func buildSyntheticNetCDF(numAtoms int, frame0 []float32) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("CDF")
+	buf.WriteByte(1) // version
+
+	binary.Write(&buf, binary.BigEndian, int32(1)) // numrecs
+
+	// dim_list
+	binary.Write(&buf, binary.BigEndian, int32(ncDimensionTag))
+	binary.Write(&buf, binary.BigEndian, int32(2))
+	writeNCName(&buf, "frame")
+	binary.Write(&buf, binary.BigEndian, int32(0)) // unlimited
+	writeNCName(&buf, "atom")
+	binary.Write(&buf, binary.BigEndian, int32(numAtoms))
+
+	// global att_list: empty
+	binary.Write(&buf, binary.BigEndian, int32(0))
+	binary.Write(&buf, binary.BigEndian, int32(0))
+
+	// var_list
+	binary.Write(&buf, binary.BigEndian, int32(ncVariableTag))
+	binary.Write(&buf, binary.BigEndian, int32(1))
+	writeNCName(&buf, "coordinates")
+	binary.Write(&buf, binary.BigEndian, int32(2)) // ndims
+	binary.Write(&buf, binary.BigEndian, int32(0)) // dimids[0] = frame
+	binary.Write(&buf, binary.BigEndian, int32(1)) // dimids[1] = atom
+	// per-var att_list: empty
+	binary.Write(&buf, binary.BigEndian, int32(0))
+	binary.Write(&buf, binary.BigEndian, int32(0))
+	binary.Write(&buf, binary.BigEndian, int32(ncFloat))
+	vsize := int32(numAtoms * 3 * 4)
+	binary.Write(&buf, binary.BigEndian, vsize)
+	// begin (int32 for version 1) points just past this field, where
+	// the frame data is appended below.
+	begin := int32(buf.Len() + 4)
+	binary.Write(&buf, binary.BigEndian, begin)
+
+	for _, v := range frame0 {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+
+	return buf.Bytes()
+}
+
+func TestNetCDFTrajectoryReaderRoundTrip(t *testing.T) {
+	numAtoms := 2
+	frame0 := []float32{1.5, 2.5, 3.5, -1.0, 0.0, 4.25}
+	data := buildSyntheticNetCDF(numAtoms, frame0)
+
+	f, err := os.CreateTemp(t.TempDir(), "synthetic-*.nc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	reader, err := newNetCDFTrajectoryReader(f.Name())
+	if err != nil {
+		t.Fatalf("newNetCDFTrajectoryReader: %v", err)
+	}
+	defer reader.Close()
+
+	r := reader.(*netCDFTrajectoryReader)
+	if r.numAtoms != numAtoms {
+		t.Fatalf("numAtoms = %d, want %d", r.numAtoms, numAtoms)
+	}
+
+	coords, box, err := reader.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	if len(coords) != len(frame0) {
+		t.Fatalf("len(coords) = %d, want %d", len(coords), len(frame0))
+	}
+	for i, want := range frame0 {
+		if coords[i] != want {
+			t.Errorf("coords[%d] = %v, want %v", i, coords[i], want)
+		}
+	}
+	if box != ([3]float32{}) {
+		t.Errorf("box = %v, want zero value (no cell_lengths variable)", box)
+	}
+}
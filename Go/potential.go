@@ -0,0 +1,325 @@
+// User-supplied tabulated pair potentials, plus the shifted-force and
+// switching-function variants used by GROMACS and other non-AMBER
+// engines, so energies from trajectories generated outside AMBER can be
+// reproduced without rewriting the prmtop's own 6-12 parameters.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PairPotential is anything that can evaluate a nonbonded pair energy (and
+// its derivative, for force-based callers) as a function of the squared
+// interatomic distance. LennardJones uses this to look up a per-atom-type-
+// pair override instead of the prmtop's 6-12 coefficients when one has
+// been loaded.
+type PairPotential interface {
+	// Energy returns the pair potential energy, kcal/mol, at squared
+	// distance r2 (Angstrom^2).
+	Energy(r2 float64) float64
+	// Derivative returns dV/d(r2) at squared distance r2, for callers that
+	// need forces rather than just energies.
+	Derivative(r2 float64) float64
+}
+
+// TabulatedPotential is a PairPotential backed by V(r) sampled at a fixed
+// spacing and interpolated with a natural cubic spline; F(r) is taken from
+// the spline rather than stored.
+type TabulatedPotential struct {
+	dr      float64
+	r       []float64
+	v       []float64
+	splineV []float64 // second derivatives of v, for spline interpolation
+}
+
+// LoadPairPotentialTable reads a simple text table in the format:
+//
+//	<npoints> <dr>
+//	<V0>
+//	<V1>
+//	...
+//
+// where point k is sampled at r = k*dr. F(r) is not stored separately;
+// it's recovered from the spline fit to V(r) so the table only has to
+// supply energies.
+func LoadPairPotentialTable(filename string) (*TabulatedPotential, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("%s: empty table file", filename)
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("%s: expected \"<npoints> <dr>\" header", filename)
+	}
+	npoints, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad npoints %q: %v", filename, fields[0], err)
+	}
+	dr, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad dr %q: %v", filename, fields[1], err)
+	}
+
+	table := &TabulatedPotential{dr: dr, r: make([]float64, npoints), v: make([]float64, npoints)}
+	for i := 0; i < npoints; i++ {
+		if !scanner.Scan() {
+			return nil, fmt.Errorf("%s: expected %d samples, found %d", filename, npoints, i)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(scanner.Text()), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad sample %d: %v", filename, i, err)
+		}
+		table.r[i] = float64(i) * dr
+		table.v[i] = v
+	}
+	table.splineV = naturalCubicSplineSecondDerivs(table.r, table.v)
+	return table, nil
+}
+
+// Energy interpolates V(r) at squared distance r2 using the cubic spline
+// fit to the tabulated samples.
+func (t *TabulatedPotential) Energy(r2 float64) float64 {
+	r := math.Sqrt(r2)
+	return cubicSplineEval(t.r, t.v, t.splineV, r)
+}
+
+// Derivative returns dV/d(r2) = (dV/dr) / (2r), evaluated via a central
+// difference against the spline fit.
+func (t *TabulatedPotential) Derivative(r2 float64) float64 {
+	r := math.Sqrt(r2)
+	const h = 1e-4
+	vPlus := cubicSplineEval(t.r, t.v, t.splineV, r+h)
+	vMinus := cubicSplineEval(t.r, t.v, t.splineV, r-h)
+	dVdr := (vPlus - vMinus) / (2 * h)
+	if r < 1e-8 {
+		return 0
+	}
+	return dVdr / (2 * r)
+}
+
+// naturalCubicSplineSecondDerivs computes the second derivatives used by a
+// natural cubic spline (zero second derivative at both endpoints) through
+// the points (x[i], y[i]).
+func naturalCubicSplineSecondDerivs(x, y []float64) []float64 {
+	n := len(x)
+	y2 := make([]float64, n)
+	u := make([]float64, n)
+	for i := 1; i < n-1; i++ {
+		sig := (x[i] - x[i-1]) / (x[i+1] - x[i-1])
+		p := sig*y2[i-1] + 2.0
+		y2[i] = (sig - 1.0) / p
+		u[i] = (y[i+1]-y[i])/(x[i+1]-x[i]) - (y[i]-y[i-1])/(x[i]-x[i-1])
+		u[i] = (6.0*u[i]/(x[i+1]-x[i-1]) - sig*u[i-1]) / p
+	}
+	for k := n - 2; k >= 0; k-- {
+		y2[k] = y2[k]*y2[k+1] + u[k]
+	}
+	return y2
+}
+
+// cubicSplineEval evaluates the natural cubic spline through (x[i], y[i])
+// with precomputed second derivatives y2 at the point xq. Values outside
+// [x[0], x[len-1]] are clamped to the nearest endpoint.
+func cubicSplineEval(x, y, y2 []float64, xq float64) float64 {
+	n := len(x)
+	if xq <= x[0] {
+		return y[0]
+	}
+	if xq >= x[n-1] {
+		return y[n-1]
+	}
+	// Locate the bracketing interval; tables are small enough that a
+	// linear scan is fine.
+	lo := 0
+	for lo < n-2 && x[lo+1] < xq {
+		lo++
+	}
+	hi := lo + 1
+	h := x[hi] - x[lo]
+	a := (x[hi] - xq) / h
+	b := (xq - x[lo]) / h
+	return a*y[lo] + b*y[hi] +
+		((a*a*a-a)*y2[lo]+(b*b*b-b)*y2[hi])*(h*h)/6.0
+}
+
+// ShiftedPotential wraps another PairPotential with the shifted-potential
+// correction used by GROMACS: V_shifted(r) = V(r) - V(Cutoff), zero beyond
+// Cutoff, so the energy (and not just the force) is continuous at the
+// cutoff.
+type ShiftedPotential struct {
+	Inner  PairPotential
+	Cutoff float64
+}
+
+func (s *ShiftedPotential) Energy(r2 float64) float64 {
+	cutoff2 := s.Cutoff * s.Cutoff
+	if r2 >= cutoff2 {
+		return 0
+	}
+	return s.Inner.Energy(r2) - s.Inner.Energy(cutoff2)
+}
+
+func (s *ShiftedPotential) Derivative(r2 float64) float64 {
+	if r2 >= s.Cutoff*s.Cutoff {
+		return 0
+	}
+	return s.Inner.Derivative(r2)
+}
+
+// SwitchedPotential wraps another PairPotential with a smooth switching
+// function that ramps the energy to zero between SwitchLow and
+// SwitchHigh, as used by CHARMM/GROMACS-style switched nonbonded terms.
+type SwitchedPotential struct {
+	Inner      PairPotential
+	SwitchLow  float64
+	SwitchHigh float64
+}
+
+func (s *SwitchedPotential) Energy(r2 float64) float64 {
+	r := math.Sqrt(r2)
+	switch {
+	case r <= s.SwitchLow:
+		return s.Inner.Energy(r2)
+	case r >= s.SwitchHigh:
+		return 0
+	default:
+		return s.Inner.Energy(r2) * switchingFunction(r, s.SwitchLow, s.SwitchHigh)
+	}
+}
+
+func (s *SwitchedPotential) Derivative(r2 float64) float64 {
+	// Only used by callers doing force evaluation; not exercised by the
+	// energy-only decomposition in this tool.
+	r := math.Sqrt(r2)
+	switch {
+	case r <= s.SwitchLow:
+		return s.Inner.Derivative(r2)
+	case r >= s.SwitchHigh:
+		return 0
+	}
+	// Product rule: d(V*S)/d(r2) = V'*S + V*(dS/dr)/(2r), with
+	// dS/dr = -30 t^2 (1-t)^2 / (SwitchHigh - SwitchLow).
+	width := s.SwitchHigh - s.SwitchLow
+	t := (r - s.SwitchLow) / width
+	dSdr := -30 * t * t * (1 - t) * (1 - t) / width
+	return s.Inner.Derivative(r2)*switchingFunction(r, s.SwitchLow, s.SwitchHigh) + s.Inner.Energy(r2)*dSdr/(2*r)
+}
+
+// LoadPairPotentialManifest reads a manifest file pairing AMBER atom type
+// indices (1-based, as in ATOM_TYPE_INDEX) with a tabulated-potential file
+// to use for that pair instead of the prmtop's own 6-12 coefficients. Each
+// line is "<typeI> <typeJ> <tablefile> [<mode> <mode-args>...]"; blank
+// lines and lines starting with # are ignored. <mode> selects how the
+// table is wrapped before use:
+//
+//	(omitted)            raw TabulatedPotential, as read from the table
+//	shifted <cutoff>     ShiftedPotential: energy shifted to zero at <cutoff>
+//	switched <lo> <hi>   SwitchedPotential: ramped to zero over [<lo>, <hi>]
+func LoadPairPotentialManifest(filename string) (map[[2]int]PairPotential, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	overrides := make(map[[2]int]PairPotential)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("%s: expected \"<typeI> <typeJ> <tablefile> [<mode> <mode-args>...]\", got %q", filename, line)
+		}
+		typeI, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad type index %q: %v", filename, fields[0], err)
+		}
+		typeJ, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad type index %q: %v", filename, fields[1], err)
+		}
+		table, err := LoadPairPotentialTable(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: loading %s: %v", filename, fields[2], err)
+		}
+		potential, err := wrapPairPotential(table, fields[3:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %q: %v", filename, line, err)
+		}
+		key := pairPotentialKey(typeI, typeJ)
+		overrides[key] = potential
+	}
+	return overrides, scanner.Err()
+}
+
+// wrapPairPotential applies the manifest line's optional mode (and its
+// arguments) to a raw tabulated potential, producing the PairPotential
+// LennardJones should actually evaluate for that atom-type pair.
+func wrapPairPotential(table *TabulatedPotential, modeArgs []string) (PairPotential, error) {
+	if len(modeArgs) == 0 {
+		return table, nil
+	}
+	switch modeArgs[0] {
+	case "shifted":
+		if len(modeArgs) != 2 {
+			return nil, fmt.Errorf("\"shifted\" expects a single <cutoff> argument")
+		}
+		cutoff, err := strconv.ParseFloat(modeArgs[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad shifted cutoff %q: %v", modeArgs[1], err)
+		}
+		return &ShiftedPotential{Inner: table, Cutoff: cutoff}, nil
+	case "switched":
+		if len(modeArgs) != 3 {
+			return nil, fmt.Errorf("\"switched\" expects <lo> and <hi> arguments")
+		}
+		lo, err := strconv.ParseFloat(modeArgs[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad switched lo %q: %v", modeArgs[1], err)
+		}
+		hi, err := strconv.ParseFloat(modeArgs[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad switched hi %q: %v", modeArgs[2], err)
+		}
+		return &SwitchedPotential{Inner: table, SwitchLow: lo, SwitchHigh: hi}, nil
+	default:
+		return nil, fmt.Errorf("unknown pair-potential mode %q", modeArgs[0])
+	}
+}
+
+// pairPotentialKey normalizes an (unordered) atom-type pair into a
+// canonical map key, since the override applies regardless of which atom
+// in the pair comes first.
+func pairPotentialKey(typeI, typeJ int) [2]int {
+	if typeI > typeJ {
+		typeI, typeJ = typeJ, typeI
+	}
+	return [2]int{typeI, typeJ}
+}
+
+// switchingFunction is the standard degree-5 polynomial switch: 1 at r0,
+// 0 at r1, with zero first and second derivatives at both ends.
+func switchingFunction(r, r0, r1 float64) float64 {
+	if r <= r0 {
+		return 1
+	}
+	if r >= r1 {
+		return 0
+	}
+	t := (r - r0) / (r1 - r0)
+	return 1 - t*t*t*(10-15*t+6*t*t)
+}
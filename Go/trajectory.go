@@ -0,0 +1,98 @@
+// Trajectory-format layer. The old reader only understood plain-text
+// (optionally gzipped) AMBER trajectories; this adds native readers for the binary
+// formats AMBER and GROMACS users actually have on disk, all exposed
+// behind a single TrajectoryReader interface so main doesn't need to know
+// which format it's reading.
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+
+	"amber"
+)
+
+// TrajectoryReader yields successive frames of coordinates (and the box,
+// if the format carries one) from a trajectory file.
+type TrajectoryReader interface {
+	// NextFrame returns the coordinates and box for the next frame, or an
+	// error (typically io.EOF) once the trajectory is exhausted.
+	NextFrame() (coords []float32, box [3]float32, err error)
+	Close() error
+}
+
+// OpenTrajectory sniffs filename's header bytes to pick a TrajectoryReader:
+// AMBER NetCDF ("CDF" magic), GROMACS XTC/TRR (their magic integers), or
+// else the legacy plain-text/gzip AMBER trajectory format.
+func OpenTrajectory(filename string, numAtoms int, hasBox bool) (TrajectoryReader, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		fmt.Println("Error opening", filename, err)
+		return nil, err
+	}
+
+	magic := make([]byte, 4)
+	n, _ := fp.Read(magic)
+	fp.Seek(0, 0)
+
+	switch {
+	case n >= 3 && string(magic[:3]) == "CDF":
+		fp.Close()
+		return newNetCDFTrajectoryReader(filename)
+	case n >= 4 && binary.BigEndian.Uint32(magic) == xtcMagic:
+		fp.Close()
+		return newXTCTrajectoryReader(filename)
+	case n >= 4 && binary.BigEndian.Uint32(magic) == trrMagic:
+		fp.Close()
+		return newTRRTrajectoryReader(filename)
+	default:
+		fp.Close()
+		return newTextTrajectoryReader(filename, numAtoms, hasBox)
+	}
+}
+
+// textTrajectoryReader adapts the legacy plain-text/gzip AMBER trajectory
+// reading (gzip chosen by a .gz suffix, then amber.GetNextFrameFromTrajectory)
+// to TrajectoryReader.
+type textTrajectoryReader struct {
+	fp       *os.File
+	reader   *bufio.Reader
+	numAtoms int
+	hasBox   bool
+}
+
+func newTextTrajectoryReader(filename string, numAtoms int, hasBox bool) (TrajectoryReader, error) {
+	trjFp, err := os.Open(filename)
+	if err != nil {
+		fmt.Println("Error opening", filename, err)
+		return nil, err
+	}
+	var reader *bufio.Reader
+	if strings.HasSuffix(filename, ".gz") {
+		inflater, err := gzip.NewReader(trjFp)
+		if err != nil {
+			fmt.Println("Not actually a gzip file: ", filename, err)
+			trjFp.Close()
+			return nil, err
+		}
+		reader = bufio.NewReader(inflater)
+	} else {
+		reader = bufio.NewReader(trjFp)
+	}
+	reader.ReadString('\n') // Eat header line
+	fmt.Println("Opened", filename)
+	return &textTrajectoryReader{fp: trjFp, reader: reader, numAtoms: numAtoms, hasBox: hasBox}, nil
+}
+
+func (t *textTrajectoryReader) NextFrame() ([]float32, [3]float32, error) {
+	coords, box, err := amber.GetNextFrameFromTrajectory(t.reader, t.numAtoms, t.hasBox)
+	return coords, box, err
+}
+
+func (t *textTrajectoryReader) Close() error {
+	return t.fp.Close()
+}
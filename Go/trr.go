@@ -0,0 +1,162 @@
+// Reader for GROMACS TRR trajectories. Unlike XTC, TRR never compresses
+// coordinates, so this is a complete reader: it just has to pick the
+// right float width (single or double precision) from the header's
+// declared x_size.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const trrMagic = 1993
+
+type trrTrajectoryReader struct {
+	fp       *os.File
+	numAtoms int
+}
+
+func newTRRTrajectoryReader(filename string) (TrajectoryReader, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Opened", filename, "(TRR)")
+	return &trrTrajectoryReader{fp: fp}, nil
+}
+
+// trrHeader mirrors the fixed-size header GROMACS writes before each
+// frame's data (xdrfile's t_trnheader).
+type trrHeader struct {
+	irSize, eSize, boxSize, virSize, presSize, topSize, symSize int32
+	xSize, vSize, fSize                                         int32
+	natoms, step, nre                                           int32
+	doublePrecision                                             bool
+}
+
+func (t *trrTrajectoryReader) readHeader() (*trrHeader, error) {
+	magic, err := readInt32(t.fp)
+	if err != nil {
+		return nil, err
+	}
+	if magic != trrMagic {
+		return nil, fmt.Errorf("trr: bad frame magic %d", magic)
+	}
+	// Version string: length-prefixed, then that many bytes padded to 4.
+	if _, err := readNCName(t.fp); err != nil {
+		return nil, err
+	}
+
+	h := &trrHeader{}
+	fields := []*int32{
+		&h.irSize, &h.eSize, &h.boxSize, &h.virSize, &h.presSize,
+		&h.topSize, &h.symSize, &h.xSize, &h.vSize, &h.fSize,
+		&h.natoms, &h.step, &h.nre,
+	}
+	for _, f := range fields {
+		v, err := readInt32(t.fp)
+		if err != nil {
+			return nil, err
+		}
+		*f = v
+	}
+	// Double precision iff x_size == natoms*3*8 rather than natoms*3*4.
+	h.doublePrecision = h.xSize == h.natoms*3*8
+
+	// t (time) and lambda, as either float32 or float64 depending on precision.
+	if h.doublePrecision {
+		if _, err := readFloat64(t.fp); err != nil {
+			return nil, err
+		}
+		if _, err := readFloat64(t.fp); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := readFloat32(t.fp); err != nil {
+			return nil, err
+		}
+		if _, err := readFloat32(t.fp); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+func (t *trrTrajectoryReader) NextFrame() ([]float32, [3]float32, error) {
+	h, err := t.readHeader()
+	if err != nil {
+		return nil, [3]float32{}, err
+	}
+
+	readReal := func() (float32, error) {
+		if h.doublePrecision {
+			v, err := readFloat64(t.fp)
+			return float32(v), err
+		}
+		return readFloat32(t.fp)
+	}
+
+	var box [3]float32
+	if h.boxSize > 0 {
+		for row := 0; row < 3; row++ {
+			for col := 0; col < 3; col++ {
+				v, err := readReal()
+				if err != nil {
+					return nil, [3]float32{}, err
+				}
+				if row == col {
+					box[row] = v * 10 // nm -> Angstrom
+				}
+			}
+		}
+	}
+	skipReals := func(count int) error {
+		for i := 0; i < count; i++ {
+			if _, err := readReal(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if h.virSize > 0 {
+		if err := skipReals(9); err != nil {
+			return nil, [3]float32{}, err
+		}
+	}
+	if h.presSize > 0 {
+		if err := skipReals(9); err != nil {
+			return nil, [3]float32{}, err
+		}
+	}
+
+	var coords []float32
+	if h.xSize > 0 {
+		coords = make([]float32, h.natoms*3)
+		for i := range coords {
+			v, err := readReal()
+			if err != nil {
+				return nil, [3]float32{}, err
+			}
+			coords[i] = v * 10 // nm -> Angstrom
+		}
+	}
+	// Velocities and forces, if present, aren't needed for energy
+	// decomposition, so skip over them rather than parsing them.
+	if h.vSize > 0 {
+		if err := skipReals(int(h.natoms) * 3); err != nil {
+			return nil, [3]float32{}, err
+		}
+	}
+	if h.fSize > 0 {
+		if err := skipReals(int(h.natoms) * 3); err != nil {
+			return nil, [3]float32{}, err
+		}
+	}
+
+	t.numAtoms = int(h.natoms)
+	return coords, box, nil
+}
+
+func (t *trrTrajectoryReader) Close() error {
+	return t.fp.Close()
+}
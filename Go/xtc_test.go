@@ -0,0 +1,96 @@
+// Correctness checks for the compressed-coordinate bitstream reader.
+// These verify xtcBitReader against byte literals worked out by hand
+// (not generated by any encoder in this package), so a bug shared
+// between an encoder and decodeBits/decodeInts couldn't hide behind a
+// self-consistent round trip.
+package main
+
+import "testing"
+
+// TestDecodeBitsKnownByte decodes a single literal byte, 0xB4
+// (0b10110100), first as its top 3 bits then its bottom 5, and checks
+// against the values worked out by hand from the bit pattern.
+func TestDecodeBitsKnownByte(t *testing.T) {
+	br := &xtcBitReader{data: []byte{0xB4}} // 1011 0100
+	if got := br.decodeBits(3); got != 0x5 { // 101
+		t.Fatalf("first 3 bits = %#x, want 0x5", got)
+	}
+	if got := br.decodeBits(5); got != 0x14 { // 10100
+		t.Fatalf("last 5 bits = %#x, want 0x14", got)
+	}
+}
+
+// TestDecodeBitsByteAligned decodes three whole bytes one at a time and
+// checks each comes back unchanged, which is the base case every
+// wider/narrower read in decodeBits builds on.
+func TestDecodeBitsByteAligned(t *testing.T) {
+	data := []byte{0x00, 0x7F, 0xFF}
+	br := &xtcBitReader{data: data}
+	for i, want := range data {
+		if got := br.decodeBits(8); got != uint32(want) {
+			t.Fatalf("byte %d = %#x, want %#x", i, got, want)
+		}
+	}
+}
+
+// TestDecodeInts decodes a known 3-byte little-endian "big number" (200
+// + 5*256 + 0*65536 = 1480) jointly packed with per-value ranges
+// sizes=[1,7,13], matching the mixed-radix division decodeInts performs:
+// nums[2] = 1480 % 13 = 11, quotient 113; nums[1] = 113 % 7 = 1, quotient
+// 16; nums[0] = 16.
+func TestDecodeInts(t *testing.T) {
+	br := &xtcBitReader{data: []byte{200, 5, 0}}
+	sizes := []uint32{1, 7, 13}
+	got := br.decodeInts(3, 24, sizes)
+	want := []int{16, 1, 11}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("decodeInts = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSizeofint(t *testing.T) {
+	cases := []struct {
+		size uint32
+		want int
+	}{
+		{0, 0},
+		{1, 1},
+		{2, 2},
+		{3, 2},
+		{255, 8},
+		{256, 9},
+	}
+	for _, c := range cases {
+		if got := sizeofint(c.size); got != c.want {
+			t.Errorf("sizeofint(%d) = %d, want %d", c.size, got, c.want)
+		}
+	}
+}
+
+// TestSizeofintsMatchesDecodeInts checks sizeofints against the number
+// of bits decodeInts actually needs to recover the same sizes without
+// running off the end of the packed field: packing the maximum value in
+// each range and reading back exactly sizeofints(sizes) bits should
+// round-trip through decodeBits/decodeInts without needing any more
+// bits than that.
+func TestSizeofintsMatchesDecodeInts(t *testing.T) {
+	sizes := [3]uint32{1, 7, 13}
+	bits := sizeofints(sizes)
+	// 24 bits (3 bytes) comfortably covers sizes this small; confirm
+	// sizeofints doesn't report more than that.
+	if bits <= 0 || bits > 24 {
+		t.Fatalf("sizeofints(%v) = %d, want a small positive bit count <= 24", sizes, bits)
+	}
+	// The maximum values (0, 6, 12) pack to 0*91 + 6*13 + 12 = 90, which
+	// sits in the top 7 bits of 0xB4 (90 << 1).
+	br := &xtcBitReader{data: []byte{0xB4}}
+	got := br.decodeInts(3, bits, sizes[:])
+	want := []int{0, 6, 12}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("decodeInts(3, %d, %v) = %v, want %v", bits, sizes, got, want)
+		}
+	}
+}